@@ -1,14 +1,21 @@
 package main
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/emicklei/go-restful"
 	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
 	"github.com/mesosphere/mesos-dns/plugins"
+	"github.com/mesosphere/mesos-dns/plugins/blocklist"
+	"github.com/mesosphere/mesos-dns/ratelimit"
 	"github.com/mesosphere/mesos-dns/records"
 	"github.com/mesosphere/mesos-dns/resolver"
 	"github.com/mesosphere/mesos-dns/util"
+	opentracing "github.com/opentracing/opentracing-go"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
@@ -20,22 +27,48 @@ type errorHandlerFunc func(string, error)
 type app struct {
 	config     records.Config
 	resolver   *resolver.Resolver
-	filters    plugins.FilterSet
 	ready      chan struct{} // when closed, indicates that initialization has completed
 	done       chan struct{} // when closed, indicates that run has completed
 	errHandler errorHandlerFunc
+
+	filterRegistry filterRegistry
+	wsRegistry     wsRegistry
+	pluginManager  *pluginManager
+	metrics        *metrics.Collector
 }
 
+// pluginContext is the plugins.Resolver a single plugin sees: it tags every
+// filter/webservice the plugin contributes with its name so that the
+// plugin lifecycle manager can strip exactly those back out on unload.
 type pluginContext struct {
 	*app
 	pluginName string
 }
 
+func (pc *pluginContext) AddFilter(f plugins.Filter) {
+	if f != nil {
+		pc.app.filterRegistry.add(pc.pluginName, f)
+	}
+}
+
+func (pc *pluginContext) RegisterWS(ws *restful.WebService) {
+	pc.app.wsRegistry.add(pc.pluginName, ws)
+}
+
+func (pc *pluginContext) OnPreload(r plugins.Reloader) {
+	pc.app.resolver.OnPreloadOwned(pc.pluginName, resolver.RecordLoader(r))
+}
+
+func (pc *pluginContext) OnPostload(r plugins.Reloader) {
+	pc.app.resolver.OnPostloadOwned(pc.pluginName, resolver.RecordLoader(r))
+}
+
 func newApp(eh errorHandlerFunc) *app {
 	c := &app{
-		errHandler: eh,
-		ready:      make(chan struct{}),
-		done:       make(chan struct{}),
+		errHandler:    eh,
+		ready:         make(chan struct{}),
+		done:          make(chan struct{}),
+		pluginManager: newPluginManager(),
 	}
 	c.initialize()
 	return c
@@ -50,43 +83,41 @@ func (c *app) Done() <-chan struct{} {
 	return c.done
 }
 
-// implements plugin.Resolver interface, panics if invoked outside of initialization process
+// Tracer returns the resolver's OpenTracing tracer, implementing
+// plugins.Resolver so that third-party plugins can add their own spans to
+// the per-query traces the resolver already records.
+func (c *app) Tracer() opentracing.Tracer {
+	return c.resolver.Tracer()
+}
+
+// MetricsRegistry returns the private registry Mesos-DNS's own metrics (and
+// /metrics itself) are served from, implementing plugins.Resolver so that
+// plugins can register their own collectors alongside them.
+func (c *app) MetricsRegistry() *prom.Registry {
+	return c.metrics.Registry()
+}
+
+// implements plugins.Resolver; OnPreload/OnPostload/AddFilter/RegisterWS can
+// be called at any time, not just during boot: pluginManager.load lets
+// plugins register these hooks long after startup, and pluginContext (not
+// *app directly) is what real plugins are handed, so that their
+// contributions can be tracked per plugin name and unloaded later.
 func (c *app) OnPreload(r plugins.Reloader) {
-	select {
-	case <-c.ready:
-		panic("cannot OnPreload after initialization has completed")
-	default:
-		c.resolver.OnPreload(resolver.RecordLoader(r))
-	}
+	c.resolver.OnPreload(resolver.RecordLoader(r))
 }
 
 func (c *app) OnPostload(r plugins.Reloader) {
-	select {
-	case <-c.ready:
-		panic("cannot OnPostload after initialization has completed")
-	default:
-		c.resolver.OnPostload(resolver.RecordLoader(r))
-	}
+	c.resolver.OnPostload(resolver.RecordLoader(r))
 }
 
 func (c *app) AddFilter(f plugins.Filter) {
-	select {
-	case <-c.ready:
-		panic("cannot AddFilter after initialization has completed")
-	default:
-	}
 	if f != nil {
-		c.filters = append(c.filters, f)
+		c.filterRegistry.add("", f)
 	}
 }
 
 func (c *app) RegisterWS(ws *restful.WebService) {
-	select {
-	case <-c.ready:
-		panic("cannot RegisterWS after initialization has completed")
-	default:
-		restful.Add(ws)
-	}
+	c.wsRegistry.add("", ws)
 }
 
 // return a clone of the global configuration, minus any plugin-specific JSON
@@ -111,41 +142,68 @@ func (c *app) initialize() {
 	c.config = records.SetConfig(*cjson)
 	c.resolver = resolver.New(version, c.config)
 
-	// launch built-in plugins
+	c.metrics = metrics.New()
+	c.resolver.SetMetrics(c.metrics)
+	c.filterRegistry.metrics = c.metrics
+	if c.config.MetricsOn {
+		c.launchMetricsServer()
+	}
+
+	// rate limiting and the refuse-ANY policy run ahead of every other
+	// filter, built-in or third-party, so a client that's over quota
+	// never reaches them
+	if f := ratelimit.Filter(c.config); f != nil {
+		c.AddFilter(f)
+	}
+
+	// launch built-in plugins through the same pluginManager that backs
+	// POST /v1/plugins/{name}, so a later hot-load under "HTTP server" or
+	// "blocklist" is rejected instead of running a second instance
+	// alongside the original.
 	if c.config.HttpOn {
-		c.launchPlugin("HTTP server", resolver.NewAPIPlugin(c.resolver))
+		if err := c.pluginManager.startBuiltin(c, "HTTP server", resolver.NewAPIPlugin(c.resolver)); err != nil {
+			logging.Error.Printf("failed to start HTTP server plugin: %v", err)
+		}
+	}
+	if len(c.config.Blocklists.Lists) > 0 {
+		p, err := blocklist.New(nil)
+		if err != nil {
+			logging.Error.Printf("failed to create blocklist plugin: %v", err)
+		} else if err := c.pluginManager.startBuiltin(c, "blocklist", p); err != nil {
+			logging.Error.Printf("failed to start blocklist plugin: %v", err)
+		}
 	}
 
-	// launch third-party plugins
+	// launch third-party plugins; the same pluginManager.load used here
+	// for boot-time plugins also backs POST /v1/plugins/{name}, so a
+	// plugin named in the config file and one loaded later at runtime are
+	// indistinguishable once running.
 	for _, pconfig := range c.config.Plugins {
 		pluginName := pconfig.Name
 		if pluginName == "" {
 			logging.Error.Printf("failed to register plugin with empty name")
 			continue
 		}
-		plugin, err := plugins.New(pluginName, pconfig.Settings)
-		if err != nil {
-			logging.Error.Printf("failed to create plugin: %v", err)
-			continue
+		if err := c.pluginManager.load(c, pluginName, pconfig.Settings); err != nil {
+			logging.Error.Printf("failed to load plugin %q: %v", pluginName, err)
 		}
-		c.launchPlugin(pluginName, plugin)
 	}
-}
 
-func (c *app) launchPlugin(pluginName string, plugin plugins.Plugin) {
-	logging.Verbose.Printf("starting plugin %q", pluginName)
-	pctx := &pluginContext{pluginName: pluginName, app: c}
-	if errCh := plugin.Start(pctx); errCh != nil {
-		go func() {
-			for err := range errCh {
-				c.errHandler(pluginName, err)
-			}
-		}()
+	if c.config.HttpOn {
+		c.registerPluginAPI()
 	}
+}
+
+// launchMetricsServer serves c.metrics's private registry at /metrics on
+// config.MetricsListen, independent of the main HTTP API so that it keeps
+// working even when HttpOn is false.
+func (c *app) launchMetricsServer() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(c.metrics.Registry(), promhttp.HandlerOpts{}))
+	srv := &http.Server{Addr: c.config.MetricsListen, Handler: mux}
 	go func() {
-		select {
-		case <-plugin.Done():
-			logging.Verbose.Printf("plugin %q terminated", pluginName)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			c.errHandler("metrics server", err)
 		}
 	}()
 }
@@ -159,15 +217,27 @@ func launchServer(enabled bool, f func() <-chan error) (errCh <-chan error) {
 
 // launch Zookeeper listener
 func (c *app) beginLeaderWatch() (newLeader <-chan struct{}, zkErr <-chan error) {
+	var leaderSrc <-chan struct{}
 	if c.config.Zk != "" {
-		newLeader, zkErr = c.resolver.LaunchZK(zkInitialDetectionTimeout)
+		leaderSrc, zkErr = c.resolver.LaunchZK(zkInitialDetectionTimeout)
 	} else {
 		// uniform behavior when new leader from masters field
 		leader := make(chan struct{}, 1)
 		leader <- struct{}{}
-		newLeader = leader
+		leaderSrc = leader
 	}
-	return
+
+	// re-emit every leader change to the caller after counting it, so
+	// run's select loop sees exactly what it did before this metric
+	// existed.
+	counted := make(chan struct{}, 1)
+	go func() {
+		for range leaderSrc {
+			c.metrics.LeaderChanges.Inc()
+			counted <- struct{}{}
+		}
+	}()
+	return counted, zkErr
 }
 
 // periodically reload DNS records, either because the reload timer expired or else
@@ -189,7 +259,9 @@ func (c *app) launchReloader() (tryReload func()) {
 		reloadTimeout := time.Second * time.Duration(c.config.RefreshSeconds)
 		reloadTimer := time.AfterFunc(reloadTimeout, tryReload)
 		for _ = range reloadSignal {
+			start := time.Now()
 			c.resolver.Reload()
+			c.metrics.ObserveReload(time.Since(start), nil)
 			logging.PrintCurLog()
 			reloadTimer.Reset(reloadTimeout)
 		}
@@ -212,7 +284,7 @@ func (c *app) run() {
 
 	// launch async server procs
 	dnsErr := launchServer(c.config.DnsOn, func() <-chan error {
-		return c.resolver.LaunchDNS(c.filters.Apply)
+		return c.resolver.LaunchDNS(c.filterRegistry.Apply)
 	})
 	newLeader, zkErr := c.beginLeaderWatch()
 	tryReload := c.launchReloader()
@@ -229,4 +301,4 @@ func (c *app) run() {
 			c.errHandler("ZK watcher", err)
 		}
 	}
-}
\ No newline at end of file
+}
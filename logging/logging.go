@@ -0,0 +1,42 @@
+// Package logging provides the loggers shared across Mesos-DNS: Error,
+// Verbose and VeryVerbose all write to stderr under their own prefix, and
+// PrintCurLog reports the totals gathered in CurLog.
+package logging
+
+import (
+	"log"
+	"os"
+)
+
+var (
+	Error       = log.New(os.Stderr, "E: ", log.LstdFlags|log.Lshortfile)
+	Verbose     = log.New(os.Stderr, "V: ", log.LstdFlags|log.Lshortfile)
+	VeryVerbose = log.New(os.Stderr, "VV: ", log.LstdFlags|log.Lshortfile)
+)
+
+// CurLog accumulates request counters that PrintCurLog flushes to Verbose.
+var CurLog struct {
+	Counters
+}
+
+// Counters tallies requests and responses observed since the last flush.
+type Counters struct {
+	Requests  int64
+	Responses int64
+
+	// DroppedRateLimit counts queries rejected by the per-client
+	// rate limiter; RefusedAny counts qtype=ANY queries answered with
+	// REFUSED instead of being resolved.
+	DroppedRateLimit int64
+	RefusedAny       int64
+}
+
+// PrintCurLog logs and resets the counters gathered since the last call.
+func PrintCurLog() {
+	Verbose.Printf("requests: %d, responses: %d, dropped_ratelimit: %d, refused_any: %d",
+		CurLog.Requests, CurLog.Responses, CurLog.DroppedRateLimit, CurLog.RefusedAny)
+	CurLog.Requests = 0
+	CurLog.Responses = 0
+	CurLog.DroppedRateLimit = 0
+	CurLog.RefusedAny = 0
+}
@@ -0,0 +1,104 @@
+// Package metrics holds the Prometheus collectors behind the built-in
+// /metrics endpoint: query counts, per-filter latency, reload outcomes,
+// ZK leader changes and the age of the last successful reload.
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector owns every metric Mesos-DNS records about itself and the
+// private registry they're served from; Registry() is what
+// plugins.Resolver.MetricsRegistry returns, so third-party plugins can
+// register alongside these without namespace collisions with the default
+// global registry.
+type Collector struct {
+	registry *prometheus.Registry
+
+	Queries         *prometheus.CounterVec
+	FilterLatency   *prometheus.HistogramVec
+	ReloadDuration  prometheus.Histogram
+	Reloads         *prometheus.CounterVec
+	LeaderChanges   prometheus.Counter
+	lastReloadNanos int64
+}
+
+// New builds and registers every Mesos-DNS collector against a fresh,
+// private registry.
+func New() *Collector {
+	c := &Collector{
+		registry: prometheus.NewRegistry(),
+		Queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "mesos_dns",
+			Name:      "queries_total",
+			Help:      "DNS queries answered, by query type and response code.",
+		}, []string{"qtype", "rcode"}),
+		FilterLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "mesos_dns",
+			Name:      "filter_duration_seconds",
+			Help:      "Time spent in each entry of the filter chain.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"filter"}),
+		ReloadDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "mesos_dns",
+			Name:      "reload_duration_seconds",
+			Help:      "Time taken to reload DNS records from Mesos state.",
+		}),
+		Reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "mesos_dns",
+			Name:      "reloads_total",
+			Help:      "Record reloads, by outcome (success or failure).",
+		}, []string{"outcome"}),
+		LeaderChanges: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "mesos_dns",
+			Name:      "leader_changes_total",
+			Help:      "Zookeeper leader-change events observed.",
+		}),
+	}
+
+	c.registry.MustRegister(
+		c.Queries,
+		c.FilterLatency,
+		c.ReloadDuration,
+		c.Reloads,
+		c.LeaderChanges,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "mesos_dns",
+			Name:      "last_reload_age_seconds",
+			Help:      "Seconds since the last successful record reload.",
+		}, c.lastReloadAge),
+	)
+
+	return c
+}
+
+// Registry is the private prometheus.Registry every Collector metric (and
+// any plugin-contributed ones) is served from.
+func (c *Collector) Registry() *prometheus.Registry {
+	return c.registry
+}
+
+// ObserveReload records the outcome and duration of a reload attempt,
+// updating the age gauge's reference point on success.
+func (c *Collector) ObserveReload(d time.Duration, err error) {
+	c.ReloadDuration.Observe(d.Seconds())
+	if err != nil {
+		c.Reloads.WithLabelValues("failure").Inc()
+		return
+	}
+	c.Reloads.WithLabelValues("success").Inc()
+	atomic.StoreInt64(&c.lastReloadNanos, time.Now().UnixNano())
+}
+
+// lastReloadAge reports -1 until the first successful reload completes, so
+// that "no reload has ever succeeded" can't be mistaken for "just reloaded".
+func (c *Collector) lastReloadAge() float64 {
+	last := atomic.LoadInt64(&c.lastReloadNanos)
+	if last == 0 {
+		return -1
+	}
+	return time.Since(time.Unix(0, last)).Seconds()
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/miekg/dns"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
+	"github.com/mesosphere/mesos-dns/plugins"
+)
+
+// filterRegistry is the mutable, hot-swappable analogue of plugins.FilterSet:
+// unlike a plain slice, entries can be appended and removed (by the plugin
+// that contributed them) while queries are concurrently flowing through
+// Apply. When metrics is set, every entry's execution time is observed
+// under its owner's name (or "core" for filters the app installs itself).
+type filterRegistry struct {
+	mu      sync.RWMutex
+	entries []filterEntry
+	metrics *metrics.Collector
+}
+
+type filterEntry struct {
+	owner  string // plugin name, or "" for filters installed by the app itself
+	filter plugins.Filter
+}
+
+func (fr *filterRegistry) add(owner string, f plugins.Filter) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.entries = append(fr.entries, filterEntry{owner: owner, filter: f})
+}
+
+// removeAll drops every filter contributed by owner, preserving the
+// relative order of everything else.
+func (fr *filterRegistry) removeAll(owner string) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	// Apply copies fr.entries' slice header under RLock and then iterates
+	// it after releasing the lock, so the backing array must never be
+	// mutated in place: compacting into fr.entries[:0] here would race
+	// with (and tear) a concurrent Apply walking the same array.
+	kept := make([]filterEntry, 0, len(fr.entries))
+	for _, e := range fr.entries {
+		if e.owner != owner {
+			kept = append(kept, e)
+		}
+	}
+	fr.entries = kept
+}
+
+// Apply runs every registered filter in order, stopping as soon as one
+// declines to continue.
+func (fr *filterRegistry) Apply(w dns.ResponseWriter, r *dns.Msg) bool {
+	fr.mu.RLock()
+	entries := fr.entries
+	m := fr.metrics
+	fr.mu.RUnlock()
+
+	for _, e := range entries {
+		start := time.Now()
+		ok := e.filter(w, r)
+		if m != nil {
+			owner := e.owner
+			if owner == "" {
+				owner = "core"
+			}
+			m.FilterLatency.WithLabelValues(owner).Observe(time.Since(start).Seconds())
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// wsRegistry tracks which go-restful WebServices each plugin registered,
+// so that unloading a plugin can remove exactly those and nothing else.
+type wsRegistry struct {
+	mu      sync.Mutex
+	byOwner map[string][]*restful.WebService
+}
+
+func (wr *wsRegistry) add(owner string, ws *restful.WebService) {
+	restful.Add(ws)
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if wr.byOwner == nil {
+		wr.byOwner = map[string][]*restful.WebService{}
+	}
+	wr.byOwner[owner] = append(wr.byOwner[owner], ws)
+}
+
+func (wr *wsRegistry) removeAll(owner string) {
+	wr.mu.Lock()
+	services := wr.byOwner[owner]
+	delete(wr.byOwner, owner)
+	wr.mu.Unlock()
+	for _, ws := range services {
+		restful.Remove(ws)
+	}
+}
+
+// pluginState is where a plugin sits in its lifecycle. Lookups that land on
+// a pluginLoading record block (via pluginManager.cond) until it settles
+// into pluginActive or pluginFailed, rather than racing against a nil
+// plugin handle.
+type pluginState int
+
+const (
+	pluginLoading pluginState = iota
+	pluginActive
+	pluginFailed
+	pluginStopped
+)
+
+func (s pluginState) String() string {
+	switch s {
+	case pluginLoading:
+		return "loading"
+	case pluginActive:
+		return "active"
+	case pluginFailed:
+		return "failed"
+	case pluginStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+type pluginRecord struct {
+	name   string
+	plugin plugins.Plugin
+	state  pluginState
+	err    error
+}
+
+// pluginManager is the hot (un)load path for plugins named via the restful
+// API, and also the path boot-time plugins are started through (load for
+// named plugins, startBuiltin for built-in ones). A sync.Cond guards state transitions so that a lookup racing
+// with activation blocks instead of observing a half-initialized plugin.
+type pluginManager struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	records map[string]*pluginRecord
+}
+
+func newPluginManager() *pluginManager {
+	pm := &pluginManager{records: map[string]*pluginRecord{}}
+	pm.cond = sync.NewCond(&pm.mu)
+	return pm
+}
+
+// lookup returns the named plugin's current record, blocking while it is
+// still loading.
+func (pm *pluginManager) lookup(name string) (*pluginRecord, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	for {
+		rec, ok := pm.records[name]
+		if !ok || rec.state != pluginLoading {
+			return rec, ok
+		}
+		pm.cond.Wait()
+	}
+}
+
+func (pm *pluginManager) snapshot() []*pluginRecord {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	out := make([]*pluginRecord, 0, len(pm.records))
+	for _, rec := range pm.records {
+		out = append(out, rec)
+	}
+	return out
+}
+
+// load creates and starts the named plugin via the plugins.New registry,
+// blocking any concurrent lookup of the same name until it settles.
+func (pm *pluginManager) load(c *app, name string, settings json.RawMessage) error {
+	rec, err := pm.reserve(name)
+	if err != nil {
+		return err
+	}
+
+	logging.Verbose.Printf("loading plugin %q", name)
+	plugin, err := plugins.New(name, settings)
+	if err != nil {
+		pm.settle(rec, nil, err)
+		return err
+	}
+	return pm.start(c, rec, plugin)
+}
+
+// startBuiltin registers an already-constructed built-in plugin (the HTTP
+// API, the blocklist updater, ...) the same way load does a named one, so
+// that it shows up in GET /v1/plugins and a later POST under the same name
+// is rejected instead of silently running two instances side by side.
+func (pm *pluginManager) startBuiltin(c *app, name string, plugin plugins.Plugin) error {
+	rec, err := pm.reserve(name)
+	if err != nil {
+		return err
+	}
+	return pm.start(c, rec, plugin)
+}
+
+// reserve claims name for a new plugin record in the loading state,
+// failing if name is already loading or active. A stopped or failed record
+// under the same name is replaced.
+func (pm *pluginManager) reserve(name string) (*pluginRecord, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if rec, ok := pm.records[name]; ok && rec.state != pluginStopped && rec.state != pluginFailed {
+		return nil, fmt.Errorf("plugin %q is already %s", name, rec.state)
+	}
+	rec := &pluginRecord{name: name, state: pluginLoading}
+	pm.records[name] = rec
+	return rec, nil
+}
+
+func (pm *pluginManager) start(c *app, rec *pluginRecord, plugin plugins.Plugin) error {
+	name := rec.name
+	pctx := &pluginContext{app: c, pluginName: name}
+	errCh := plugin.Start(pctx)
+	if errCh != nil {
+		go func() {
+			for err := range errCh {
+				c.errHandler(name, err)
+			}
+		}()
+	}
+	go func() {
+		<-plugin.Done()
+		logging.Verbose.Printf("plugin %q terminated", name)
+	}()
+
+	pm.settle(rec, plugin, nil)
+	return nil
+}
+
+func (pm *pluginManager) settle(rec *pluginRecord, plugin plugins.Plugin, err error) {
+	pm.mu.Lock()
+	rec.plugin = plugin
+	rec.err = err
+	if err != nil {
+		rec.state = pluginFailed
+	} else {
+		rec.state = pluginActive
+	}
+	pm.mu.Unlock()
+	pm.cond.Broadcast()
+}
+
+// stopper is implemented by plugins that need to do more than stop
+// contributing filters/webservices when unloaded, e.g. tearing down a
+// background goroutine.
+type stopper interface {
+	Stop()
+}
+
+// unload stops an active plugin and strips its filters and webservices
+// from c, returning an error if name isn't currently active.
+func (pm *pluginManager) unload(c *app, name string) error {
+	pm.mu.Lock()
+	rec, ok := pm.records[name]
+	if !ok || rec.state != pluginActive {
+		pm.mu.Unlock()
+		return fmt.Errorf("plugin %q is not active", name)
+	}
+	rec.state = pluginStopped
+	pm.mu.Unlock()
+	pm.cond.Broadcast()
+
+	c.filterRegistry.removeAll(name)
+	c.wsRegistry.removeAll(name)
+	c.resolver.RemoveOwner(name)
+	if s, ok := rec.plugin.(stopper); ok {
+		s.Stop()
+	}
+	return nil
+}
+
+// registerPluginAPI exposes the plugin lifecycle over the existing
+// go-restful surface: GET lists every known plugin and its state, POST
+// loads one by name with JSON settings, DELETE stops and unregisters one.
+func (c *app) registerPluginAPI() {
+	ws := new(restful.WebService)
+	ws.Path("/v1/plugins")
+	ws.Consumes(restful.MIME_JSON)
+	ws.Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("").To(c.listPlugins))
+	ws.Route(ws.GET("/{name}").To(c.getPlugin))
+	ws.Route(ws.POST("/{name}").To(c.loadPlugin))
+	ws.Route(ws.DELETE("/{name}").To(c.unloadPlugin))
+
+	c.wsRegistry.add("", ws)
+}
+
+// pluginStatus is the JSON shape returned by GET /v1/plugins and
+// GET /v1/plugins/{name}.
+type pluginStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+func newPluginStatus(rec *pluginRecord) pluginStatus {
+	ps := pluginStatus{Name: rec.name, State: rec.state.String()}
+	if rec.err != nil {
+		ps.Error = rec.err.Error()
+	}
+	return ps
+}
+
+func (c *app) listPlugins(req *restful.Request, resp *restful.Response) {
+	var out []pluginStatus
+	for _, rec := range c.pluginManager.snapshot() {
+		out = append(out, newPluginStatus(rec))
+	}
+	resp.WriteEntity(out)
+}
+
+// getPlugin reports a single plugin's status, waiting out a concurrent
+// load via pluginManager.lookup rather than racing it.
+func (c *app) getPlugin(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	rec, ok := c.pluginManager.lookup(name)
+	if !ok {
+		resp.WriteErrorString(http.StatusNotFound, fmt.Sprintf("plugin %q not found", name))
+		return
+	}
+	resp.WriteEntity(newPluginStatus(rec))
+}
+
+func (c *app) loadPlugin(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	settings, err := ioutil.ReadAll(req.Request.Body)
+	if err != nil {
+		resp.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := c.pluginManager.load(c, name, json.RawMessage(settings)); err != nil {
+		resp.WriteErrorString(http.StatusConflict, err.Error())
+		return
+	}
+	resp.WriteHeader(http.StatusCreated)
+}
+
+func (c *app) unloadPlugin(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	if err := c.pluginManager.unload(c, name); err != nil {
+		resp.WriteErrorString(http.StatusNotFound, err.Error())
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,159 @@
+// Package blocklist is a built-in Mesos-DNS plugin that fetches one or
+// more hosts-format or domains-format blocklists over HTTP(S), compiles
+// them into a trie, and rejects matching queries before they reach the
+// record store. It is launched the same way as the HTTP API: directly
+// from app.initialize, guarded by whether any list is configured.
+package blocklist
+
+import (
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/plugins"
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+func init() {
+	plugins.Register("blocklist", New)
+}
+
+// compiled is the result of one fetch-and-build pass, swapped in whole so
+// that an in-flight query always sees either the old or the new set, never
+// a partially-updated one.
+type compiled struct {
+	block, allow *domainTrie
+}
+
+// Plugin fetches and compiles the configured lists and installs itself as
+// a plugins.Filter.
+type Plugin struct {
+	cfg  records.BlocklistsConfig
+	sets atomic.Value // of *compiled
+	stop chan struct{}
+	done chan struct{}
+	errc chan error
+}
+
+// New constructs the blocklist plugin. settings are ignored: whether it is
+// launched as a built-in or loaded by name through plugins.New, the plugin
+// is configured through records.Config.Blocklists.
+func New(settings json.RawMessage) (plugins.Plugin, error) {
+	return &Plugin{stop: make(chan struct{}), done: make(chan struct{})}, nil
+}
+
+// Start implements plugins.Plugin: it does an initial synchronous fetch so
+// the filter is immediately usable, installs the filter, and then
+// refreshes the whole list set on a single ticker (see refreshLoop).
+func (p *Plugin) Start(ctx plugins.Resolver) <-chan error {
+	p.cfg = ctx.Config().Blocklists
+	if p.cfg.Sinkhole != "" && net.ParseIP(p.cfg.Sinkhole) == nil {
+		logging.Error.Printf("blocklist: sinkhole %q is not a valid IP, falling back to NXDOMAIN", p.cfg.Sinkhole)
+		p.cfg.Sinkhole = ""
+	}
+
+	block, allow := fetchAll(p.cfg.Lists)
+	p.sets.Store(&compiled{block: block, allow: allow})
+
+	ctx.AddFilter(p.filter)
+	// Also recompile alongside every resolver reload (app.launchReloader),
+	// in addition to the periodic refresh in refreshLoop below.
+	ctx.OnPostload(reloadHook{p})
+
+	p.errc = make(chan error)
+	go p.refreshLoop()
+	return p.errc
+}
+
+// reloadHook re-fetches and recompiles the list set whenever the resolver
+// reloads its records, satisfying plugins.Reloader.
+type reloadHook struct{ p *Plugin }
+
+func (h reloadHook) Reload(rg plugins.RecordGenerator) error {
+	block, allow := fetchAll(h.p.cfg.Lists)
+	h.p.sets.Store(&compiled{block: block, allow: allow})
+	return nil
+}
+
+// refreshLoop re-fetches and recompiles the full list set on the shortest
+// configured refresh interval, tolerating per-list failures as fetchAll
+// already does, until Stop closes p.stop.
+func (p *Plugin) refreshLoop() {
+	defer close(p.done)
+	interval := p.shortestInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			block, allow := fetchAll(p.cfg.Lists)
+			p.sets.Store(&compiled{block: block, allow: allow})
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *Plugin) shortestInterval() time.Duration {
+	var shortest time.Duration
+	for _, src := range p.cfg.Lists {
+		if d := time.Duration(src.RefreshSeconds) * time.Second; d > 0 && (shortest == 0 || d < shortest) {
+			shortest = d
+		}
+	}
+	if shortest == 0 {
+		shortest = time.Duration(records.DefaultRefreshSeconds) * time.Second
+	}
+	return shortest
+}
+
+// filter implements plugins.Filter: queries for blocked domains that
+// aren't also allow-listed get NXDOMAIN, or the configured sinkhole
+// record, instead of being resolved.
+func (p *Plugin) filter(w dns.ResponseWriter, r *dns.Msg) bool {
+	if len(r.Question) == 0 {
+		return true
+	}
+	cur := p.sets.Load().(*compiled)
+	qname := r.Question[0].Name
+	if cur.allow.match(qname) || !cur.block.match(qname) {
+		return true
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if p.cfg.Sinkhole == "" {
+		m.Rcode = dns.RcodeNameError
+	} else if ip := net.ParseIP(p.cfg.Sinkhole); ip != nil {
+		rr, err := dns.NewRR(sinkholeRR(qname, ip))
+		if err == nil {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	w.WriteMsg(m)
+	return false
+}
+
+func sinkholeRR(qname string, ip net.IP) string {
+	if ip.To4() == nil {
+		return qname + " 0 IN AAAA " + ip.String()
+	}
+	return qname + " 0 IN A " + ip.String()
+}
+
+// Stop tells refreshLoop to exit and closes errc, implementing the
+// pluginManager's stopper interface so that unloading blocklist actually
+// stops it fetching lists instead of leaving refreshLoop running forever.
+func (p *Plugin) Stop() {
+	close(p.stop)
+	close(p.errc)
+}
+
+// Done is closed once refreshLoop has exited.
+func (p *Plugin) Done() <-chan struct{} {
+	return p.done
+}
@@ -0,0 +1,91 @@
+package blocklist
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+// maxConcurrentFetches bounds how many lists are downloaded at once so
+// that a large Blocklists config doesn't open one socket per entry.
+const maxConcurrentFetches = 8
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// fetchAll downloads every configured list concurrently, tolerating
+// per-list failures, and returns the compiled block/allow tries. A list
+// that fails to download or parse is logged and skipped rather than
+// aborting the whole refresh.
+func fetchAll(sources []records.BlocklistSource) (block, allow *domainTrie) {
+	block, allow = newDomainTrie(), newDomainTrie()
+
+	type result struct {
+		domains []string
+		allow   bool
+	}
+	results := make(chan result, len(sources))
+	sem := make(chan struct{}, maxConcurrentFetches)
+
+	for _, src := range sources {
+		src := src
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem }()
+			domains, err := fetchOne(src.URL)
+			if err != nil {
+				logging.Error.Printf("blocklist: failed to fetch %s: %v", src.URL, err)
+				results <- result{}
+				return
+			}
+			results <- result{domains: domains, allow: src.Mode == "allow"}
+		}()
+	}
+
+	for range sources {
+		r := <-results
+		dst := block
+		if r.allow {
+			dst = allow
+		}
+		for _, d := range r.domains {
+			dst.add(d)
+		}
+	}
+
+	return block, allow
+}
+
+// fetchOne downloads a single hosts-format or domains-format list and
+// returns the domains it names.
+//
+// Both formats are accepted line by line: a bare domain ("ads.example.com"),
+// or a hosts-file entry ("0.0.0.0 ads.example.com"), with "#" comments and
+// blank lines ignored.
+func fetchOne(url string) ([]string, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		domain := fields[len(fields)-1]
+		domains = append(domains, domain)
+	}
+	return domains, scanner.Err()
+}
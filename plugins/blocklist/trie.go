@@ -0,0 +1,61 @@
+package blocklist
+
+import "strings"
+
+// domainTrie indexes domains by label, most-significant label first (i.e.
+// "ads.example.com." is stored root -> "com" -> "example" -> "ads"), so
+// that a lookup for "sub.ads.example.com." matches on the "ads.example.com"
+// node without needing every subdomain to be listed individually.
+type domainTrie struct {
+	blocked  bool
+	children map[string]*domainTrie
+}
+
+func newDomainTrie() *domainTrie {
+	return &domainTrie{children: map[string]*domainTrie{}}
+}
+
+// add inserts domain (and everything under it) into the trie.
+func (t *domainTrie) add(domain string) {
+	labels := reversedLabels(domain)
+	node := t
+	for _, label := range labels {
+		child, ok := node.children[label]
+		if !ok {
+			child = newDomainTrie()
+			node.children[label] = child
+		}
+		node = child
+	}
+	node.blocked = true
+}
+
+// match reports whether qname (or an ancestor domain of it) was added to
+// the trie.
+func (t *domainTrie) match(qname string) bool {
+	labels := reversedLabels(qname)
+	node := t
+	for _, label := range labels {
+		child, ok := node.children[label]
+		if !ok {
+			return false
+		}
+		if child.blocked {
+			return true
+		}
+		node = child
+	}
+	return false
+}
+
+func reversedLabels(domain string) []string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return nil
+	}
+	labels := strings.Split(domain, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return labels
+}
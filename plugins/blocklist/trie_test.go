@@ -0,0 +1,31 @@
+package blocklist
+
+import "testing"
+
+func TestDomainTrieMatch(t *testing.T) {
+	tr := newDomainTrie()
+	tr.add("ads.example.com.")
+
+	cases := []struct {
+		qname string
+		want  bool
+	}{
+		{"ads.example.com.", true},
+		{"sub.ads.example.com.", true},
+		{"ADS.EXAMPLE.COM.", true},
+		{"example.com.", false},
+		{"other.com.", false},
+	}
+	for _, c := range cases {
+		if got := tr.match(c.qname); got != c.want {
+			t.Errorf("match(%q) = %v, want %v", c.qname, got, c.want)
+		}
+	}
+}
+
+func TestDomainTrieEmpty(t *testing.T) {
+	tr := newDomainTrie()
+	if tr.match("example.com.") {
+		t.Fatal("empty trie should match nothing")
+	}
+}
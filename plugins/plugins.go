@@ -0,0 +1,108 @@
+// Package plugins defines the extension surface that built-in and
+// third-party Mesos-DNS plugins are written against: the Resolver facade
+// handed to every plugin at startup, the Filter chain that every query
+// flows through, and the registry used to instantiate plugins named in
+// the config file.
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/emicklei/go-restful"
+	"github.com/miekg/dns"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+// Filter inspects or rewrites a query before it reaches the record store.
+// A Filter that has already written a response should return false to
+// stop the chain; returning true lets subsequent filters (and eventually
+// the resolver itself) continue handling the query.
+type Filter func(w dns.ResponseWriter, r *dns.Msg) bool
+
+// FilterSet is an ordered chain of Filters, invoked in registration order.
+type FilterSet []Filter
+
+// Apply runs every filter in order, stopping as soon as one declines to
+// continue. It reports whether the resolver should still resolve r.
+func (fs FilterSet) Apply(w dns.ResponseWriter, r *dns.Msg) bool {
+	for _, f := range fs {
+		if !f(w, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reloader is notified before (OnPreload) or after (OnPostload) the
+// resolver refreshes its record set from the Mesos master.
+type Reloader interface {
+	Reload(rg RecordGenerator) error
+}
+
+// RecordGenerator is the subset of the resolver's record store that
+// reload hooks are allowed to observe.
+type RecordGenerator interface {
+	// Exists reports whether the given name is already present in the
+	// record set under construction.
+	Exists(name string) bool
+}
+
+// Resolver is the facade that the app hands to every plugin at startup.
+// None of these methods are limited to startup: a plugin loaded (or
+// reloaded) at runtime via the restful API calls OnPreload/OnPostload/
+// AddFilter/RegisterWS exactly the same way a boot-time plugin does, and
+// the app tracks each contribution by plugin name so that unloading the
+// plugin removes it again.
+type Resolver interface {
+	// Config returns a clone of the global configuration.
+	Config() *records.Config
+	OnPreload(Reloader)
+	OnPostload(Reloader)
+	AddFilter(Filter)
+	RegisterWS(*restful.WebService)
+	// Tracer returns the resolver's OpenTracing tracer so that plugins can
+	// create spans alongside the ones the resolver records for each query.
+	Tracer() opentracing.Tracer
+	// MetricsRegistry returns the private Prometheus registry metrics are
+	// served from, so that plugins can register their own collectors
+	// without colliding with Mesos-DNS's own metric names.
+	MetricsRegistry() *prometheus.Registry
+	// Done is closed once the resolver's run loop has exited.
+	Done() <-chan struct{}
+}
+
+// Plugin is a long-lived component started alongside the resolver, e.g.
+// the built-in HTTP API or a blocklist updater.
+type Plugin interface {
+	// Start launches the plugin against ctx and returns a channel of
+	// asynchronous errors, or nil if the plugin doesn't report any.
+	Start(ctx Resolver) <-chan error
+	// Done is closed once the plugin has fully stopped.
+	Done() <-chan struct{}
+}
+
+// Factory constructs a Plugin from its raw JSON settings.
+type Factory func(settings json.RawMessage) (Plugin, error)
+
+var registry = map[string]Factory{}
+
+// Register makes a plugin factory available under name for use in the
+// config file's "plugins" section. It is expected to be called from an
+// init() func in the plugin's package.
+func Register(name string, f Factory) {
+	registry[name] = f
+}
+
+// New instantiates the plugin registered under name with the given raw
+// JSON settings.
+func New(name string, settings json.RawMessage) (Plugin, error) {
+	f, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("no plugin registered under name %q", name)
+	}
+	return f(settings)
+}
@@ -0,0 +1,165 @@
+// Package ratelimit implements the built-in per-client rate limiting and
+// refuse-ANY filter, installed by app.initialize ahead of any third-party
+// plugin's filters so that both protections apply before a query reaches
+// the rest of the chain.
+package ratelimit
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+// shardCount controls how many independently-locked buckets maps the
+// limiter is split across, so that concurrent queries from different
+// clients rarely contend on the same lock.
+const shardCount = 32
+
+// idleTTL is how long a client's bucket may sit unused before gc reclaims
+// it.
+const idleTTL = 5 * time.Minute
+
+// Filter builds the plugins.Filter enforcing cfg.RateLimit/RateLimitBurst
+// and cfg.RefuseAny. It returns nil if both are disabled, so callers can
+// unconditionally try to install it.
+func Filter(cfg records.Config) func(w dns.ResponseWriter, r *dns.Msg) bool {
+	var limiter *limiter
+	if cfg.RateLimit > 0 {
+		limiter = newLimiter(cfg.RateLimit, cfg.RateLimitBurst)
+	}
+	if limiter == nil && !cfg.RefuseAny {
+		return nil
+	}
+
+	return func(w dns.ResponseWriter, r *dns.Msg) bool {
+		if limiter != nil && w.RemoteAddr() != nil {
+			if !limiter.allow(clientKey(w.RemoteAddr())) {
+				atomic.AddInt64(&logging.CurLog.DroppedRateLimit, 1)
+				return false
+			}
+		}
+
+		if cfg.RefuseAny && len(r.Question) > 0 && r.Question[0].Qtype == dns.TypeANY {
+			atomic.AddInt64(&logging.CurLog.RefusedAny, 1)
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeRefused)
+			w.WriteMsg(m)
+			return false
+		}
+
+		return true
+	}
+}
+
+func clientKey(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// limiter is a sharded map of per-client token buckets. The hot path
+// (allow) only ever takes one shard's lock, and holds it while it finds or
+// creates the bucket, refills it and takes a token; the token count is not
+// updated atomically. Only lastSeen is stored atomically, after the lock is
+// released.
+type limiter struct {
+	rate  float64 // tokens added per second
+	burst int64
+
+	shards [shardCount]shard
+}
+
+type shard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens   int64 // fixed-point: actual tokens * 1e6
+	lastSeen int64 // unix nanos, updated atomically
+	lastFill int64 // unix nanos, guarded by the shard lock
+}
+
+const tokenScale = 1e6
+
+func newLimiter(rate float64, burst int) *limiter {
+	l := &limiter{rate: rate, burst: int64(burst)}
+	for i := range l.shards {
+		l.shards[i].buckets = map[string]*bucket{}
+	}
+	go l.gc()
+	return l
+}
+
+func (l *limiter) shardFor(key string) *shard {
+	return &l.shards[fnv32(key)%shardCount]
+}
+
+func (l *limiter) allow(key string) bool {
+	s := l.shardFor(key)
+
+	s.mu.Lock()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst * tokenScale, lastFill: time.Now().UnixNano()}
+		s.buckets[key] = b
+	}
+	now := time.Now().UnixNano()
+	elapsed := float64(now-b.lastFill) / float64(time.Second)
+	b.tokens += int64(elapsed * l.rate * tokenScale)
+	if max := l.burst * tokenScale; b.tokens > max {
+		b.tokens = max
+	}
+	b.lastFill = now
+	allowed := b.tokens >= tokenScale
+	if allowed {
+		b.tokens -= tokenScale
+	}
+	s.mu.Unlock()
+
+	atomic.StoreInt64(&b.lastSeen, now)
+	return allowed
+}
+
+// gc periodically drops buckets that haven't been touched in idleTTL, so
+// that an attacker spraying queries from many source IPs can't grow the
+// limiter's memory without bound.
+func (l *limiter) gc() {
+	ticker := time.NewTicker(idleTTL)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-idleTTL).UnixNano()
+		for i := range l.shards {
+			s := &l.shards[i]
+			s.mu.Lock()
+			for key, b := range s.buckets {
+				if atomic.LoadInt64(&b.lastSeen) < cutoff {
+					delete(s.buckets, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// fnv32 is a tiny non-cryptographic string hash used to pick a shard.
+func fnv32(s string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	h := uint32(offset32)
+	for i := 0; i < len(s); i++ {
+		h *= prime32
+		h ^= uint32(s[i])
+	}
+	return h
+}
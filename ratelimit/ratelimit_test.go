@@ -0,0 +1,28 @@
+package ratelimit
+
+import "testing"
+
+func TestLimiterAllowBurst(t *testing.T) {
+	l := newLimiter(1, 3)
+	for i := 0; i < 3; i++ {
+		if !l.allow("client") {
+			t.Fatalf("request %d: expected burst capacity to allow it", i)
+		}
+	}
+	if l.allow("client") {
+		t.Fatal("expected the 4th request to exceed burst capacity")
+	}
+}
+
+func TestLimiterAllowPerClient(t *testing.T) {
+	l := newLimiter(1, 1)
+	if !l.allow("a") {
+		t.Fatal("expected first request from a new client to be allowed")
+	}
+	if !l.allow("b") {
+		t.Fatal("a separate client should have its own bucket")
+	}
+	if l.allow("a") {
+		t.Fatal("client a already spent its burst token")
+	}
+}
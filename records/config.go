@@ -0,0 +1,124 @@
+// Package records holds the Mesos-DNS configuration format and the
+// generated DNS record set built from Mesos state.
+package records
+
+import "encoding/json"
+
+// Default values applied by SetConfig to any zero-valued field.
+const (
+	DefaultRefreshSeconds = 60
+	DefaultHTTPSPath      = "/dns-query"
+	DefaultRateLimitBurst = 10
+	DefaultMetricsListen  = ":9153"
+)
+
+// PluginConfig names a third-party plugin to load and the raw JSON
+// settings to hand it.
+type PluginConfig struct {
+	Name     string          `json:"name"`
+	Settings json.RawMessage `json:"settings,omitempty"`
+}
+
+// TracingConfig controls the optional OpenTracing integration: which
+// provider to report spans to, where to reach it, and what fraction of
+// queries to sample.
+type TracingConfig struct {
+	Provider   string  `json:"provider,omitempty"`   // e.g. "jaeger", "zipkin"
+	Endpoint   string  `json:"endpoint,omitempty"`   // collector/agent address
+	SampleRate float64 `json:"sampleRate,omitempty"` // 0.0-1.0, defaults to 1.0
+}
+
+// Config is the fully-resolved Mesos-DNS configuration, built by SetConfig
+// from the JSON config file plus any command-line overrides.
+type Config struct {
+	Zk             string   `json:"zk"`
+	Masters        []string `json:"masters"`
+	Resolvers      []string `json:"resolvers"`
+	RefreshSeconds int      `json:"refreshSeconds"`
+
+	HttpOn bool `json:"httpOn"`
+	DnsOn  bool `json:"dnsOn"`
+
+	// TLSListen, when non-empty, brings up a DNS-over-TLS (RFC 7858)
+	// listener on that address using TLSCertFile/TLSKeyFile.
+	TLSListen   string `json:"tlsListen,omitempty"`
+	TLSCertFile string `json:"tlsCertFile,omitempty"`
+	TLSKeyFile  string `json:"tlsKeyFile,omitempty"`
+
+	// HTTPSListen, when non-empty, brings up a DNS-over-HTTPS (RFC 8484)
+	// listener on that address, serving both GET and POST under
+	// HTTPSPath (defaults to "/dns-query"). Reuses TLSCertFile/TLSKeyFile.
+	HTTPSListen string `json:"httpsListen,omitempty"`
+	HTTPSPath   string `json:"httpsPath,omitempty"`
+
+	// RateLimit is the per-source-IP queries-per-second allowance; zero
+	// disables rate limiting. RateLimitBurst caps how many queries a
+	// single IP may burst above that steady rate.
+	RateLimit      float64 `json:"rateLimit,omitempty"`
+	RateLimitBurst int     `json:"rateLimitBurst,omitempty"`
+
+	// RefuseAny answers qtype=ANY with REFUSED (RFC 8482 recommends
+	// HINFO, but REFUSED is simpler and just as effective against the
+	// amplification use case) instead of expanding the full record set.
+	RefuseAny bool `json:"refuseAny,omitempty"`
+
+	// MetricsOn brings up a Prometheus /metrics endpoint on MetricsListen,
+	// registered against a private registry so that plugins can add their
+	// own collectors via plugins.Resolver.MetricsRegistry without
+	// colliding with Mesos-DNS's own metric names.
+	MetricsOn     bool   `json:"metricsOn,omitempty"`
+	MetricsListen string `json:"metricsListen,omitempty"`
+
+	Plugins    []PluginConfig   `json:"plugins,omitempty"`
+	Tracing    TracingConfig    `json:"tracing,omitempty"`
+	Blocklists BlocklistsConfig `json:"blocklists,omitempty"`
+}
+
+// BlocklistsConfig configures the built-in blocklist/allowlist plugin.
+type BlocklistsConfig struct {
+	Lists []BlocklistSource `json:"lists,omitempty"`
+	// Sinkhole, if set, answers a blocked query with this A/AAAA record
+	// instead of NXDOMAIN.
+	Sinkhole string `json:"sinkhole,omitempty"`
+}
+
+// BlocklistSource is a single remote list of domains fetched and compiled
+// at startup and on every reload tick.
+type BlocklistSource struct {
+	URL            string `json:"url"`
+	RefreshSeconds int    `json:"refreshSeconds,omitempty"`
+	// Mode is "block" (default) or "allow": an allow list exempts its
+	// domains from every block list.
+	Mode string `json:"mode,omitempty"`
+}
+
+// SetConfig fills any zero-valued field of c with its default and returns
+// the result. It never mutates c in place so that callers holding the
+// original value (e.g. the flag-parsed *cjson) are unaffected.
+func SetConfig(c Config) Config {
+	if c.RefreshSeconds == 0 {
+		c.RefreshSeconds = DefaultRefreshSeconds
+	}
+	if c.Tracing.SampleRate == 0 {
+		c.Tracing.SampleRate = 1.0
+	}
+	if c.HTTPSListen != "" && c.HTTPSPath == "" {
+		c.HTTPSPath = DefaultHTTPSPath
+	}
+	if c.RateLimit > 0 && c.RateLimitBurst == 0 {
+		c.RateLimitBurst = DefaultRateLimitBurst
+	}
+	if c.MetricsOn && c.MetricsListen == "" {
+		c.MetricsListen = DefaultMetricsListen
+	}
+	c.Blocklists.Lists = append([]BlocklistSource(nil), c.Blocklists.Lists...)
+	for i := range c.Blocklists.Lists {
+		if c.Blocklists.Lists[i].RefreshSeconds == 0 {
+			c.Blocklists.Lists[i].RefreshSeconds = DefaultRefreshSeconds
+		}
+		if c.Blocklists.Lists[i].Mode == "" {
+			c.Blocklists.Lists[i].Mode = "block"
+		}
+	}
+	return c
+}
@@ -0,0 +1,55 @@
+package resolver
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+// dohQueryParam extracts the base64url, no-padding "dns" query parameter
+// used by the GET form of DNS-over-HTTPS (RFC 8484 section 4.1).
+func dohQueryParam(req *http.Request) ([]byte, error) {
+	q := req.URL.Query().Get("dns")
+	if q == "" {
+		return nil, errors.New("missing dns query parameter")
+	}
+	return base64.RawURLEncoding.DecodeString(q)
+}
+
+// dohResponseWriter adapts the request/response pair of a DoH exchange to
+// dns.ResponseWriter so that the shared filters.Apply chain and resolve
+// logic can run unmodified over HTTP.
+type dohResponseWriter struct {
+	remote string
+	msg    *dns.Msg
+}
+
+func (w *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *dohResponseWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+
+func (w *dohResponseWriter) Close() error        { return nil }
+func (w *dohResponseWriter) TsigStatus() error   { return nil }
+func (w *dohResponseWriter) TsigTimersOnly(bool) {}
+func (w *dohResponseWriter) Hijack()             {}
+func (w *dohResponseWriter) LocalAddr() net.Addr { return nil }
+func (w *dohResponseWriter) RemoteAddr() net.Addr {
+	host, _, err := net.SplitHostPort(w.remote)
+	if err != nil {
+		host = w.remote
+	}
+	return &net.IPAddr{IP: net.ParseIP(host)}
+}
@@ -0,0 +1,255 @@
+// Package resolver turns Mesos state into DNS answers: it owns the
+// record set, the preload/postload reload hooks, and the UDP/TCP server
+// loop that Filters sit in front of.
+package resolver
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/miekg/dns"
+	opentracing "github.com/opentracing/opentracing-go"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
+	"github.com/mesosphere/mesos-dns/plugins"
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+// RecordLoader is a reload hook as the record set stores it; any
+// plugins.Reloader converts to it directly, and its Reload is run against
+// the *RecordGenerator being rebuilt.
+type RecordLoader plugins.Reloader
+
+// RecordGenerator owns the current DNS record set and knows how to
+// rebuild it from Mesos state.
+type RecordGenerator struct {
+	mu      sync.RWMutex
+	records map[string][]dns.RR
+}
+
+// Exists reports whether name is already present in the record set under
+// construction, satisfying plugins.RecordGenerator.
+func (rg *RecordGenerator) Exists(name string) bool {
+	rg.mu.RLock()
+	defer rg.mu.RUnlock()
+	_, ok := rg.records[name]
+	return ok
+}
+
+// Resolver answers DNS queries against a RecordGenerator kept up to date
+// by periodic reloads from Mesos and/or Zookeeper leader changes.
+type Resolver struct {
+	version string
+	config  records.Config
+
+	rg      *RecordGenerator
+	tracer  opentracing.Tracer
+	metrics *metrics.Collector
+
+	servers     []*dns.Server
+	httpServers []*http.Server
+
+	mu        sync.Mutex
+	preloads  []reloadEntry
+	postloads []reloadEntry
+}
+
+// reloadEntry tags a reload hook with the owner that registered it (a
+// plugin name, or "" for hooks the app installs itself), so that an
+// unloaded plugin's hooks can be found and removed again.
+type reloadEntry struct {
+	owner string
+	hook  RecordLoader
+}
+
+// New creates a Resolver for the given config; version is reported by the
+// HTTP API's /v1/version endpoint.
+func New(version string, config records.Config) *Resolver {
+	return &Resolver{
+		version: version,
+		config:  config,
+		rg:      &RecordGenerator{records: map[string][]dns.RR{}},
+		tracer:  newTracer(config.Tracing),
+	}
+}
+
+// SetMetrics attaches the collector that resolve uses to record query
+// counts by qtype/rcode. It's a no-op to query without calling this first:
+// the resolver works the same either way, just without that instrumentation.
+func (res *Resolver) SetMetrics(m *metrics.Collector) {
+	res.metrics = m
+}
+
+// OnPreload registers a hook run before each reload rebuilds the record
+// set, attributed to no particular owner.
+func (res *Resolver) OnPreload(r RecordLoader) {
+	res.OnPreloadOwned("", r)
+}
+
+// OnPostload registers a hook run after each reload rebuilds the record
+// set, attributed to no particular owner.
+func (res *Resolver) OnPostload(r RecordLoader) {
+	res.OnPostloadOwned("", r)
+}
+
+// OnPreloadOwned is OnPreload, tagging the hook with owner (a plugin name)
+// so that RemoveOwner can find it again on unload.
+func (res *Resolver) OnPreloadOwned(owner string, r RecordLoader) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.preloads = append(res.preloads, reloadEntry{owner: owner, hook: r})
+}
+
+// OnPostloadOwned is OnPostload, tagging the hook with owner (a plugin
+// name) so that RemoveOwner can find it again on unload.
+func (res *Resolver) OnPostloadOwned(owner string, r RecordLoader) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.postloads = append(res.postloads, reloadEntry{owner: owner, hook: r})
+}
+
+// RemoveOwner drops every preload/postload hook contributed by owner,
+// preserving the relative order of everything else. It's the reload-hook
+// analogue of the plugin manager's filterRegistry/wsRegistry teardown, so
+// that unloading a plugin also stops its reload hooks from firing.
+func (res *Resolver) RemoveOwner(owner string) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.preloads = dropOwner(res.preloads, owner)
+	res.postloads = dropOwner(res.postloads, owner)
+}
+
+func dropOwner(entries []reloadEntry, owner string) []reloadEntry {
+	kept := make([]reloadEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.owner != owner {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// Reload rebuilds the record set from current Mesos state, running any
+// registered preload/postload hooks around the rebuild.
+func (res *Resolver) Reload() {
+	res.mu.Lock()
+	preloads, postloads := res.preloads, res.postloads
+	res.mu.Unlock()
+
+	for _, e := range preloads {
+		if err := e.hook.Reload(res.rg); err != nil {
+			logging.Error.Printf("preload hook failed: %v", err)
+		}
+	}
+
+	// TODO(jdef) actually rebuild res.rg from Mesos state here.
+
+	for _, e := range postloads {
+		if err := e.hook.Reload(res.rg); err != nil {
+			logging.Error.Printf("postload hook failed: %v", err)
+		}
+	}
+}
+
+// LaunchZK starts watching Zookeeper for leader changes, reporting a new
+// leader on the returned channel once one is detected within timeout.
+func (res *Resolver) LaunchZK(timeout time.Duration) (newLeader <-chan struct{}, zkErr <-chan error) {
+	leader := make(chan struct{}, 1)
+	errc := make(chan error, 1)
+	// TODO(jdef) wire up an actual Zookeeper watch; for now report a
+	// leader immediately so callers make progress.
+	leader <- struct{}{}
+	newLeader, zkErr = leader, errc
+	return
+}
+
+// LaunchDNS starts the classic UDP/TCP DNS listeners plus, if configured,
+// DNS-over-TLS and DNS-over-HTTPS listeners, all sharing apply and the
+// same record store. It returns a channel of asynchronous server errors.
+// The returned listeners can be torn down together via Shutdown.
+func (res *Resolver) LaunchDNS(apply func(w dns.ResponseWriter, r *dns.Msg) bool) <-chan error {
+	errc := make(chan error, 4)
+
+	for _, net := range []string{"udp", "tcp"} {
+		handler := dns.HandlerFunc(res.traced(net, apply, res.resolve))
+		srv := &dns.Server{Addr: res.listenAddr(), Net: net, Handler: handler}
+		res.servers = append(res.servers, srv)
+		go func(srv *dns.Server) {
+			if err := srv.ListenAndServe(); err != nil {
+				errc <- err
+			}
+		}(srv)
+	}
+
+	if res.config.TLSListen != "" {
+		handler := dns.HandlerFunc(res.traced("tcp-tls", apply, res.resolve))
+		if srv := res.launchTLS(handler, errc); srv != nil {
+			res.servers = append(res.servers, srv)
+		}
+	}
+
+	if res.config.HTTPSListen != "" {
+		srv := res.launchHTTPS(apply, errc)
+		res.httpServers = append(res.httpServers, srv)
+	}
+
+	return errc
+}
+
+// Shutdown gracefully tears down every listener started by LaunchDNS.
+func (res *Resolver) Shutdown() {
+	for _, srv := range res.servers {
+		srv.Shutdown()
+	}
+	for _, srv := range res.httpServers {
+		srv.Close()
+	}
+}
+
+func (res *Resolver) listenAddr() string {
+	if len(res.config.Resolvers) > 0 {
+		return res.config.Resolvers[0]
+	}
+	return ":53"
+}
+
+// resolve answers r directly from the current record set.
+func (res *Resolver) resolve(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	w.WriteMsg(m)
+
+	if res.metrics != nil && len(r.Question) > 0 {
+		qtype := dns.TypeToString[r.Question[0].Qtype]
+		rcode := dns.RcodeToString[m.Rcode]
+		res.metrics.Queries.WithLabelValues(qtype, rcode).Inc()
+	}
+}
+
+// NewAPIPlugin wraps the resolver's go-restful HTTP API as a plugins.Plugin
+// so it can be launched through the same lifecycle as third-party plugins.
+func NewAPIPlugin(res *Resolver) plugins.Plugin {
+	return &apiPlugin{res: res, done: make(chan struct{})}
+}
+
+type apiPlugin struct {
+	res  *Resolver
+	done chan struct{}
+}
+
+func (p *apiPlugin) Start(ctx plugins.Resolver) <-chan error {
+	ws := new(restful.WebService)
+	ws.Path("/v1")
+	ws.Route(ws.GET("/version").To(func(req *restful.Request, resp *restful.Response) {
+		resp.WriteEntity(map[string]string{"version": p.res.version})
+	}))
+	ctx.RegisterWS(ws)
+	return nil
+}
+
+func (p *apiPlugin) Done() <-chan struct{} {
+	return p.done
+}
@@ -0,0 +1,98 @@
+package resolver
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+// launchTLS brings up a DNS-over-TLS (RFC 7858) listener sharing the same
+// filters.Apply chain and record store as the classic UDP/TCP listeners.
+// It is equivalent to a "tcp-tls" dns.Server and returns errors on errc.
+func (res *Resolver) launchTLS(handler dns.Handler, errc chan<- error) *dns.Server {
+	cert, err := tls.LoadX509KeyPair(res.config.TLSCertFile, res.config.TLSKeyFile)
+	if err != nil {
+		errc <- err
+		return nil
+	}
+
+	srv := &dns.Server{
+		Addr:      res.config.TLSListen,
+		Net:       "tcp-tls",
+		Handler:   handler,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil {
+			errc <- err
+		}
+	}()
+	return srv
+}
+
+// launchHTTPS brings up a DNS-over-HTTPS (RFC 8484) listener at
+// config.HTTPSPath, accepting both the GET (base64url "dns" query param)
+// and POST (application/dns-message body) forms of the wire format,
+// running every query through apply before resolving it.
+func (res *Resolver) launchHTTPS(apply func(w dns.ResponseWriter, r *dns.Msg) bool, errc chan<- error) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(res.config.HTTPSPath, func(w http.ResponseWriter, req *http.Request) {
+		// Bound the POST body to the largest possible DNS message before
+		// doing anything else with it, so an oversized request can't be
+		// used for memory amplification ahead of the rate limiter, which
+		// only runs once apply sees the parsed message below.
+		req.Body = http.MaxBytesReader(w, req.Body, dns.MaxMsgSize)
+		msg, err := readDoHMessage(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rw := &dohResponseWriter{remote: req.RemoteAddr}
+		res.traced("https", apply, res.resolve)(rw, msg)
+		writeDoHReply(w, rw.msg)
+	})
+
+	srv := &http.Server{Addr: res.config.HTTPSListen, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServeTLS(res.config.TLSCertFile, res.config.TLSKeyFile); err != nil && err != http.ErrServerClosed {
+			errc <- err
+		}
+	}()
+	return srv
+}
+
+func readDoHMessage(req *http.Request) (*dns.Msg, error) {
+	var wire []byte
+	var err error
+	switch req.Method {
+	case http.MethodPost:
+		wire, err = ioutil.ReadAll(req.Body)
+	default:
+		wire, err = dohQueryParam(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(wire); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func writeDoHReply(w http.ResponseWriter, m *dns.Msg) {
+	if m == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	wire, err := m.Pack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(wire)
+}
@@ -0,0 +1,92 @@
+package resolver
+
+import (
+	"github.com/miekg/dns"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+// newTracer builds the opentracing.Tracer described by cfg. An empty
+// Provider disables tracing: Tracer() still returns a usable no-op
+// implementation so callers never need to nil-check it.
+func newTracer(cfg records.TracingConfig) opentracing.Tracer {
+	if cfg.Provider == "" {
+		return opentracing.NoopTracer{}
+	}
+	// TODO(jdef) dial the configured provider (jaeger, zipkin, ...) at
+	// cfg.Endpoint with sampling rate cfg.SampleRate. Until a concrete
+	// backend is wired in, fall back to the global tracer so that spans
+	// created here are at least visible to whatever the process installed
+	// via opentracing.SetGlobalTracer.
+	return opentracing.GlobalTracer()
+}
+
+// Tracer returns the resolver's tracer, satisfying plugins.Resolver so
+// that plugins registered via AddFilter/OnPreload can create their own
+// spans alongside the ones the resolver itself records.
+func (res *Resolver) Tracer() opentracing.Tracer {
+	return res.tracer
+}
+
+// traced wraps the whole per-query lifecycle -- the apply (filters.Apply)
+// chain plus the eventual resolve -- in a root span, tagging it with the
+// fields operators need to diagnose tail-latency in the filter pipeline:
+// qname, qtype, protocol, client, and finally rcode/answer count once the
+// reply is known. A child span covers filter execution specifically,
+// leaving room for record-lookup and upstream-recursion spans to be added
+// as children of the root later.
+func (res *Resolver) traced(net string, apply func(w dns.ResponseWriter, r *dns.Msg) bool, resolve func(w dns.ResponseWriter, r *dns.Msg)) func(w dns.ResponseWriter, r *dns.Msg) {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		var qname, qtype string
+		if len(r.Question) > 0 {
+			qname, qtype = r.Question[0].Name, dns.TypeToString[r.Question[0].Qtype]
+		}
+
+		span := res.tracer.StartSpan("dns.query")
+		ext.SpanKindRPCServer.Set(span)
+		span.SetTag("qname", qname)
+		span.SetTag("qtype", qtype)
+		span.SetTag("protocol", net)
+		if w != nil && w.RemoteAddr() != nil {
+			span.SetTag("client", w.RemoteAddr().String())
+		}
+		defer span.Finish()
+
+		filterSpan := res.tracer.StartSpan("filters.apply", opentracing.ChildOf(span.Context()))
+		ok := apply(w, r)
+		filterSpan.Finish()
+
+		if !ok {
+			span.SetTag("rcode", "none")
+			span.SetTag("answers", 0)
+			return
+		}
+
+		capture := &spanCapturingWriter{ResponseWriter: w}
+		resolve(capture, r)
+		recordResult(span, capture.msg)
+	}
+}
+
+// recordResult tags span with the outcome of a resolved query.
+func recordResult(span opentracing.Span, m *dns.Msg) {
+	if span == nil || m == nil {
+		return
+	}
+	span.SetTag("rcode", dns.RcodeToString[m.Rcode])
+	span.SetTag("answers", len(m.Answer))
+}
+
+// spanCapturingWriter records the dns.Msg a handler writes so that traced
+// can tag the root span with its rcode/answer count after the fact.
+type spanCapturingWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (s *spanCapturingWriter) WriteMsg(m *dns.Msg) error {
+	s.msg = m
+	return s.ResponseWriter.WriteMsg(m)
+}
@@ -0,0 +1,19 @@
+// Package util holds small helpers shared across Mesos-DNS that don't
+// belong to any single package.
+package util
+
+import (
+	"runtime/debug"
+
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// HandleCrash recovers from a panic in the calling goroutine, logs the
+// stack trace, and lets the goroutine return normally instead of taking
+// the whole process down. It should be deferred at the top of any
+// long-running goroutine.
+func HandleCrash() {
+	if r := recover(); r != nil {
+		logging.Error.Printf("recovered from panic: %v\n%s", r, debug.Stack())
+	}
+}